@@ -0,0 +1,79 @@
+package fission
+
+import (
+	"context"
+	"sync"
+	"unsafe"
+)
+
+// inFlightStruct tracks the context.CancelFunc for every callback currently
+// running in processDevFuseFDReadBuf, keyed by inHeader.Unique, so
+// OpCodeInterrupt (and unmount/reader-exit) can cancel it promptly instead
+// of leaving a slow backend call to run to completion.
+type inFlightStruct struct {
+	sync.Mutex
+	cancelFuncs map[uint64]context.CancelFunc
+}
+
+func (volume *volumeStruct) inFlightRegister(unique uint64) (ctx context.Context) {
+	var cancel context.CancelFunc
+
+	ctx, cancel = context.WithCancel(context.Background())
+
+	volume.inFlight.Lock()
+	volume.inFlight.cancelFuncs[unique] = cancel
+	volume.inFlight.Unlock()
+
+	return
+}
+
+func (volume *volumeStruct) inFlightDeregister(unique uint64) {
+	var (
+		cancel context.CancelFunc
+		ok     bool
+	)
+
+	volume.inFlight.Lock()
+	cancel, ok = volume.inFlight.cancelFuncs[unique]
+	if ok {
+		delete(volume.inFlight.cancelFuncs, unique)
+	}
+	volume.inFlight.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+// inFlightCancelAll cancels every currently-registered callback context. It
+// is called on DoUnmount and again in the primary devFuseFDReader's exit
+// path, before it waits on callbacksWG, so no callback is left blocked on a
+// slow backend while the reader waits for it.
+func (volume *volumeStruct) inFlightCancelAll() {
+	var (
+		cancel      context.CancelFunc
+		cancelFuncs []context.CancelFunc
+	)
+
+	volume.inFlight.Lock()
+	cancelFuncs = make([]context.CancelFunc, 0, len(volume.inFlight.cancelFuncs))
+	for _, cancel = range volume.inFlight.cancelFuncs {
+		cancelFuncs = append(cancelFuncs, cancel)
+	}
+	volume.inFlight.cancelFuncs = make(map[uint64]context.CancelFunc)
+	volume.inFlight.Unlock()
+
+	for _, cancel = range cancelFuncs {
+		cancel()
+	}
+}
+
+// doInterrupt looks up the outstanding request named in the InterruptIn
+// payload and cancels its context, letting a callback blocked on a slow
+// backend (e.g. a Read or Write against remote storage) return promptly
+// when the kernel reports the calling process was interrupted (Ctrl-C).
+func (volume *volumeStruct) doInterrupt(ctx context.Context, inHeader *InHeader, buf []byte) {
+	var interruptIn = (*InterruptIn)(unsafe.Pointer(&buf[0]))
+
+	volume.inFlightDeregister(interruptIn.Unique)
+}
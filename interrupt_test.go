@@ -0,0 +1,75 @@
+package fission
+
+import (
+	"context"
+	"testing"
+)
+
+func newTestVolumeWithInFlight() *volumeStruct {
+	var volume = &volumeStruct{}
+	volume.inFlight.cancelFuncs = make(map[uint64]context.CancelFunc)
+	return volume
+}
+
+func TestInFlightRegisterDeregisterCancelsContext(t *testing.T) {
+	var (
+		unique uint64 = 1
+		volume        = newTestVolumeWithInFlight()
+		ctx           = volume.inFlightRegister(unique)
+	)
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("context cancelled before deregister")
+	default:
+	}
+
+	volume.inFlightDeregister(unique)
+
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("expected context to be cancelled by inFlightDeregister")
+	}
+
+	volume.inFlight.Lock()
+	if 0 != len(volume.inFlight.cancelFuncs) {
+		t.Fatal("expected cancelFuncs to be empty after deregister")
+	}
+	volume.inFlight.Unlock()
+}
+
+func TestInFlightDeregisterUnknownUniqueIsANoOp(t *testing.T) {
+	var volume = newTestVolumeWithInFlight()
+
+	// Must not panic when no such request was ever registered (e.g. an
+	// OpCodeInterrupt for a request that already completed).
+	volume.inFlightDeregister(999)
+}
+
+func TestInFlightCancelAllCancelsEveryRegisteredContext(t *testing.T) {
+	var (
+		volume = newTestVolumeWithInFlight()
+		ctxA   = volume.inFlightRegister(1)
+		ctxB   = volume.inFlightRegister(2)
+	)
+
+	volume.inFlightCancelAll()
+
+	select {
+	case <-ctxA.Done():
+	default:
+		t.Fatal("expected first context to be cancelled by inFlightCancelAll")
+	}
+	select {
+	case <-ctxB.Done():
+	default:
+		t.Fatal("expected second context to be cancelled by inFlightCancelAll")
+	}
+
+	volume.inFlight.Lock()
+	if 0 != len(volume.inFlight.cancelFuncs) {
+		t.Fatal("expected cancelFuncs to be empty after cancelAll")
+	}
+	volume.inFlight.Unlock()
+}
@@ -0,0 +1,54 @@
+package fission
+
+import (
+	"log"
+	"syscall"
+	"time"
+)
+
+// Logger lets a caller route fission's diagnostic output through their own
+// logging stack (zap, zerolog, an OpenTelemetry log bridge, ...) instead of
+// the standard library's *log.Logger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Tracer is invoked at the top and bottom of processDevFuseFDReadBuf for
+// every dispatched op, letting a caller emit per-op histograms or correlate
+// slow FUSE requests back to the calling PID. start is true on entry, false
+// on exit; latency and errno are only meaningful when start is false.
+type Tracer interface {
+	Trace(opCode uint32, unique uint64, nodeID uint64, uid uint32, gid uint32, pid uint32, start bool, latency time.Duration, errno syscall.Errno)
+}
+
+// StdLogAdapter wraps a *log.Logger so it satisfies Logger, preserving
+// backward compatibility for callers that already hold a configured
+// *log.Logger: pass NewStdLogAdapter(yourLogger) wherever newVolume wants a
+// Logger.
+type StdLogAdapter struct {
+	logger *log.Logger
+}
+
+// NewStdLogAdapter wraps logger so it satisfies Logger.
+func NewStdLogAdapter(logger *log.Logger) *StdLogAdapter {
+	return &StdLogAdapter{logger: logger}
+}
+
+func (adapter *StdLogAdapter) Debugf(format string, args ...interface{}) {
+	adapter.logger.Printf(format, args...)
+}
+
+func (adapter *StdLogAdapter) Infof(format string, args ...interface{}) {
+	adapter.logger.Printf(format, args...)
+}
+
+func (adapter *StdLogAdapter) Warnf(format string, args ...interface{}) {
+	adapter.logger.Printf(format, args...)
+}
+
+func (adapter *StdLogAdapter) Errorf(format string, args ...interface{}) {
+	adapter.logger.Printf(format, args...)
+}
@@ -0,0 +1,163 @@
+// +build darwin
+
+package fission
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// macFUSEExchangeIn4xFlagsSize is the extra 8-byte flags field macFUSE 4.x
+// appends after the standard RenameIn payload; it must be stripped before
+// dispatching OpCodeRename through the shared (Linux-derived) handler.
+const macFUSEExchangeIn4xFlagsSize = 8
+
+// mountMacFUSEHelper is the macFUSE mount helper invoked by DoMount. osxfuse
+// 3.x ships the same helper under a different name.
+const mountMacFUSEHelper = "/Library/Filesystems/macfuse.fs/Contents/Resources/mount_macfuse"
+const mountOSXFuse3Helper = "/Library/Filesystems/osxfuse.fs/Contents/Resources/mount_osxfusefs"
+
+// unmountFlags is MNT_FORCE from <sys/mount.h>. Unlike on linux, the standard
+// library's syscall package does not export it on darwin, so it is declared
+// here as the raw flag value unmount(2) expects. DoUnmount (volume.go) is
+// shared across platforms and uses this instead of syscall.MNT_FORCE.
+const unmountFlags = 0x00080000
+
+// DoMount spawns the macFUSE (or osxfuse 3.x) mount helper and hands it one
+// end of a socketpair via ExtraFiles, since Darwin has no /dev/fuse to open
+// directly the way Linux does. The helper mounts the filesystem, opens the
+// kernel's FUSE device itself, and passes that fd back to us over the
+// socket using SCM_RIGHTS.
+func (volume *volumeStruct) DoMount() (err error) {
+	var (
+		cmd            *exec.Cmd
+		controlMsgs    []syscall.SocketControlMessage
+		fds            []int
+		helperPath     string
+		n              int
+		oob            = make([]byte, syscall.CmsgSpace(4))
+		oobN           int
+		sock           [2]int
+		theirSock      *os.File
+		devFuseFDBuf   = make([]byte, 4)
+	)
+
+	_ = syscall.Unmount(volume.mountpointDirPath, unmountFlags)
+
+	helperPath = mountMacFUSEHelper
+	if _, err = os.Stat(helperPath); nil != err {
+		helperPath = mountOSXFuse3Helper
+	}
+
+	sock, err = syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if nil != err {
+		volume.logger.Errorf("Volume %s unable to create socketpair for %s: %v", volume.volumeName, helperPath, err)
+		return
+	}
+
+	theirSock = os.NewFile(uintptr(sock[1]), "macfuse-mount-helper-sock")
+
+	cmd = exec.Command(helperPath, volume.mountpointDirPath)
+	cmd.ExtraFiles = []*os.File{theirSock}
+	cmd.Env = append(os.Environ(),
+		"MOUNT_FUSE_CALL_BY_LIB=1",
+		"MOUNT_FUSE_DAEMON_PATH="+os.Args[0],
+		fmt.Sprintf("_FUSE_COMMFD=%d", 3), // first (and only) entry in ExtraFiles
+		"_FUSE_COMMVERS=2",
+	)
+
+	err = cmd.Start()
+	_ = theirSock.Close()
+	if nil != err {
+		volume.logger.Errorf("Volume %s unable to start %s: %v", volume.volumeName, helperPath, err)
+		_ = syscall.Close(sock[0])
+		return
+	}
+
+	n, oobN, _, _, err = syscall.Recvmsg(sock[0], devFuseFDBuf, oob, 0)
+	_ = syscall.Close(sock[0])
+	if nil != err {
+		volume.logger.Errorf("Volume %s unable to receive /dev/fuse fd from %s: %v", volume.volumeName, helperPath, err)
+		return
+	}
+	if 0 == n {
+		volume.logger.Errorf("Volume %s received empty /dev/fuse fd handoff from %s", volume.volumeName, helperPath)
+		err = syscall.EIO
+		return
+	}
+
+	controlMsgs, err = syscall.ParseSocketControlMessage(oob[:oobN])
+	if nil != err {
+		volume.logger.Errorf("Volume %s unable to parse control message from %s: %v", volume.volumeName, helperPath, err)
+		return
+	}
+	if 0 == len(controlMsgs) {
+		volume.logger.Errorf("Volume %s received no control messages from %s", volume.volumeName, helperPath)
+		err = syscall.EIO
+		return
+	}
+
+	fds, err = syscall.ParseUnixRights(&controlMsgs[0])
+	if nil != err || 0 == len(fds) {
+		volume.logger.Errorf("Volume %s unable to parse rights from %s: %v", volume.volumeName, helperPath, err)
+		return
+	}
+
+	volume.devFuseFD = fds[0]
+	volume.macFUSEVersion = detectMacFUSEVersion(helperPath)
+
+	volume.devFuseFDReaderWG.Add(1)
+	go volume.devFuseFDReader(volume.devFuseFD)
+
+	volume.logger.Infof("Volume %s mounted on mountpoint %s via %s", volume.volumeName, volume.mountpointDirPath, helperPath)
+
+	return
+}
+
+// detectMacFUSEVersion best-efforts a major version number for the mount
+// helper in use, so processDevFuseFDReadBuf knows whether to strip the
+// macFUSE 4.x RenameIn flags trailer.
+func detectMacFUSEVersion(helperPath string) (version int) {
+	var (
+		out []byte
+		err error
+	)
+
+	out, err = exec.Command(helperPath, "--version").Output()
+	if nil != err {
+		return 3 // osxfuse 3.x helper has no --version; assume the older wire format
+	}
+
+	version, err = strconv.Atoi(strings.SplitN(strings.TrimSpace(string(out)), ".", 2)[0])
+	if nil != err {
+		return 3
+	}
+
+	return
+}
+
+// renameInFixedSize is the wire size of the fixed portion of fuse_rename_in
+// (see <linux/fuse.h>): a single uint64 newdir field. The two NUL-terminated
+// name strings follow immediately after it (and after macFUSE 4.x's extra
+// flags field, on that wire format).
+const renameInFixedSize = 8
+
+// renameInBuf strips the extra 8-byte flags field macFUSE 4.x inserts
+// between the fixed RenameIn struct and the oldname/newname strings that
+// follow it, so OpCodeRename can be dispatched through the same
+// (Linux-derived) handler on both wire formats.
+func (volume *volumeStruct) renameInBuf(buf []byte) []byte {
+	if volume.macFUSEVersion < 4 {
+		return buf
+	}
+	if len(buf) < renameInFixedSize+macFUSEExchangeIn4xFlagsSize {
+		return buf
+	}
+	// Splice out the flags field sitting right after the fixed struct;
+	// slicing off the tail of buf instead would truncate newname.
+	return append(buf[:renameInFixedSize:renameInFixedSize], buf[renameInFixedSize+macFUSEExchangeIn4xFlagsSize:]...)
+}
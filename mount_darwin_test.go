@@ -0,0 +1,48 @@
+// +build darwin
+
+package fission
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRenameInBufSplicesOutMacFUSE4xFlagsField(t *testing.T) {
+	var (
+		volume = &volumeStruct{macFUSEVersion: 4}
+		fixed  = []byte{1, 2, 3, 4, 5, 6, 7, 8} // fuse_rename_in.newdir
+		flags  = []byte{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF, 0x11, 0x22}
+		names  = []byte("oldname\x00newname\x00")
+		buf    = append(append(append([]byte{}, fixed...), flags...), names...)
+		want   = append(append([]byte{}, fixed...), names...)
+		got    = volume.renameInBuf(buf)
+	)
+
+	if !bytes.Equal(want, got) {
+		t.Fatalf("renameInBuf() = %v, want %v (newname must survive intact)", got, want)
+	}
+}
+
+func TestRenameInBufIsANoOpBelowMacFUSE4(t *testing.T) {
+	var (
+		volume = &volumeStruct{macFUSEVersion: 3}
+		buf    = []byte{1, 2, 3, 4, 5, 6, 7, 8, 'a', 0, 'b', 0}
+		got    = volume.renameInBuf(buf)
+	)
+
+	if !bytes.Equal(buf, got) {
+		t.Fatalf("renameInBuf() = %v, want unchanged %v", got, buf)
+	}
+}
+
+func TestRenameInBufLeavesShortBufUntouched(t *testing.T) {
+	var (
+		volume = &volumeStruct{macFUSEVersion: 4}
+		buf    = []byte{1, 2, 3}
+		got    = volume.renameInBuf(buf)
+	)
+
+	if !bytes.Equal(buf, got) {
+		t.Fatalf("renameInBuf() = %v, want unchanged %v", got, buf)
+	}
+}
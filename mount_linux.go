@@ -0,0 +1,74 @@
+// +build linux
+
+package fission
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// unmountFlags is syscall.MNT_FORCE. DoUnmount (volume.go) is shared across
+// platforms and uses this instead of syscall.MNT_FORCE directly, since the
+// symbol isn't available in the syscall package on darwin; see
+// mount_darwin.go for that platform's equivalent.
+const unmountFlags = syscall.MNT_FORCE
+
+// DoMount opens /dev/fuse directly and passes its fd to the kernel via the
+// fd= mount option. This is the Linux mount path; see mount_darwin.go for
+// the macFUSE socket-handoff equivalent.
+func (volume *volumeStruct) DoMount() (err error) {
+	var (
+		devFuseFDMountOption string
+		gid                  int
+		gidMountOption       string
+		mountOptions         string
+		rootMode             uint32
+		rootModeMountOption  string
+		uid                  int
+		uidMountOption       string
+	)
+
+	_ = syscall.Unmount(volume.mountpointDirPath, unmountFlags)
+
+	volume.devFuseFD, err = syscall.Open("/dev/fuse", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if nil != err {
+		volume.logger.Errorf("Volume %s unable to open /dev/fuse", volume.volumeName)
+		return
+	}
+
+	volume.initCapFlags |= spliceCapFlags()
+
+	volume.devFuseFDReaderWG.Add(1)
+	go volume.devFuseFDReader(volume.devFuseFD)
+
+	devFuseFDMountOption = fmt.Sprintf("fd=%d", volume.devFuseFD)
+
+	rootMode = syscall.S_IFDIR
+	rootModeMountOption = fmt.Sprintf("rootmode=%o", rootMode)
+
+	uid = syscall.Geteuid()
+	gid = syscall.Getegid()
+
+	uidMountOption = fmt.Sprintf("user_id=%d", uid)
+	gidMountOption = fmt.Sprintf("group_id=%d", gid)
+
+	mountOptions = devFuseFDMountOption + "," + rootModeMountOption + "," + uidMountOption + "," + gidMountOption
+
+	err = syscall.Mount(volume.volumeName, volume.mountpointDirPath, "fuse", volume.mountFlags, mountOptions)
+	if nil == err {
+		volume.logger.Infof("Volume %s mounted on mountpoint %s", volume.volumeName, volume.mountpointDirPath)
+		volume.spawnClonedReaders()
+	} else {
+		volume.logger.Errorf("Volume %s mount on mountpoint %s failed: %v", volume.volumeName, volume.mountpointDirPath, err)
+		_ = syscall.Close(volume.devFuseFD)
+		volume.devFuseFDReaderWG.Wait()
+	}
+
+	return
+}
+
+// renameInBuf is a no-op on Linux; the macFUSE 4.x flags trailer stripped
+// here on Darwin never appears on the wire.
+func (volume *volumeStruct) renameInBuf(buf []byte) []byte {
+	return buf
+}
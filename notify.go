@@ -0,0 +1,255 @@
+package fission
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// notifyRetrieveTimeout bounds how long NotifyRetrieve waits for the
+// kernel's OpCodeNotifyReply before giving up on a pending request.
+const notifyRetrieveTimeout = 30 * time.Second
+
+// Notification codes the kernel accepts in OutHeader.Error for messages sent
+// unsolicited (OutHeader.Unique == 0), rather than as a reply to a specific
+// request. See <linux/fuse.h> fuse_notify_code.
+const (
+	notifyCodeInvalInode = -1
+	notifyCodeInvalEntry = -2
+	notifyCodeStore      = -4
+	notifyCodeRetrieve   = -5
+	notifyCodeDelete     = -6
+	notifyCodePoll       = -7
+)
+
+// notifierStruct tracks NotifyRetrieve() calls awaiting their
+// OpCodeNotifyReply from the kernel, keyed by the NotifyUnique value we
+// handed out.
+type notifierStruct struct {
+	sync.Mutex
+	nextUnique      uint64
+	pendingRetrieve map[uint64]chan []byte
+}
+
+func (volume *volumeStruct) notifyWriter(notifyCode int32, bufs ...[]byte) (err error) {
+	var (
+		buf          []byte
+		bufIndex     int
+		bytesWritten uintptr
+		errno        syscall.Errno
+		iovec        []syscall.Iovec
+		iovecSpan    uintptr
+		outHeader    []byte
+	)
+
+	iovec = make([]syscall.Iovec, len(bufs)+1)
+	iovecSpan = 0
+
+	for bufIndex, buf = range bufs {
+		iovec[bufIndex+1] = syscall.Iovec{Base: &buf[0], Len: uint64(len(buf))}
+		iovecSpan += uintptr(len(buf))
+	}
+
+	outHeader = make([]byte, OutHeaderSize)
+	iovecSpan += uintptr(OutHeaderSize)
+
+	*(*uint32)(unsafe.Pointer(&outHeader[0])) = uint32(iovecSpan)
+	*(*int32)(unsafe.Pointer(&outHeader[4])) = notifyCode
+	*(*uint64)(unsafe.Pointer(&outHeader[8])) = 0 // Unique == 0 marks this as an unsolicited notification
+
+	iovec[0] = syscall.Iovec{Base: &outHeader[0], Len: uint64(OutHeaderSize)}
+
+	bytesWritten, _, errno = syscall.Syscall(
+		syscall.SYS_WRITEV,
+		uintptr(volume.devFuseFD),
+		uintptr(unsafe.Pointer(&iovec[0])),
+		uintptr(len(iovec)))
+	if 0 != errno {
+		volume.logger.Errorf("Notify write to /dev/fuse returned bad errno: %v", errno)
+		err = errno
+		return
+	}
+	if bytesWritten != iovecSpan {
+		volume.logger.Warnf("Notify write to /dev/fuse returned bad bytesWritten: %v", bytesWritten)
+	}
+
+	err = nil
+	return
+}
+
+// NotifyInvalInode tells the kernel to drop any cached pages for nodeID
+// covering [off, off+len), or the entire inode if len < 0. This is used by
+// filesystems whose backing store can change out-of-band from the mount.
+func (volume *volumeStruct) NotifyInvalInode(nodeID uint64, off int64, len int64) (err error) {
+	var notifyInvalInodeOut = make([]byte, NotifyInvalInodeOutSize)
+
+	*(*uint64)(unsafe.Pointer(&notifyInvalInodeOut[0])) = nodeID
+	*(*int64)(unsafe.Pointer(&notifyInvalInodeOut[8])) = off
+	*(*int64)(unsafe.Pointer(&notifyInvalInodeOut[16])) = len
+
+	err = volume.notifyWriter(notifyCodeInvalInode, notifyInvalInodeOut)
+	return
+}
+
+// NotifyInvalEntry tells the kernel to drop the dentry cache entry named
+// name under directory inode parent.
+func (volume *volumeStruct) NotifyInvalEntry(parent uint64, name string) (err error) {
+	var (
+		nameBuf             = append([]byte(name), 0)
+		notifyInvalEntryOut = make([]byte, NotifyInvalEntryOutSize)
+	)
+
+	*(*uint64)(unsafe.Pointer(&notifyInvalEntryOut[0])) = parent
+	*(*uint32)(unsafe.Pointer(&notifyInvalEntryOut[8])) = uint32(len(name))
+
+	err = volume.notifyWriter(notifyCodeInvalEntry, notifyInvalEntryOut, nameBuf)
+	return
+}
+
+// NotifyDelete behaves like NotifyInvalEntry but also lets the kernel skip
+// the invalidation if the dentry no longer points at child, avoiding a race
+// against a concurrent lookup that already replaced it.
+func (volume *volumeStruct) NotifyDelete(parent uint64, child uint64, name string) (err error) {
+	var (
+		nameBuf         = append([]byte(name), 0)
+		notifyDeleteOut = make([]byte, NotifyDeleteOutSize)
+	)
+
+	*(*uint64)(unsafe.Pointer(&notifyDeleteOut[0])) = parent
+	*(*uint64)(unsafe.Pointer(&notifyDeleteOut[8])) = child
+	*(*uint32)(unsafe.Pointer(&notifyDeleteOut[16])) = uint32(len(name))
+
+	err = volume.notifyWriter(notifyCodeDelete, notifyDeleteOut, nameBuf)
+	return
+}
+
+// NotifyStore pushes data into the kernel's page cache for nodeID at offset
+// off, for write-back caching filesystems that receive writes out-of-band
+// from the mount (e.g. from a cluster peer).
+func (volume *volumeStruct) NotifyStore(nodeID uint64, off uint64, data []byte) (err error) {
+	var notifyStoreOut = make([]byte, NotifyStoreOutSize)
+
+	*(*uint64)(unsafe.Pointer(&notifyStoreOut[0])) = nodeID
+	*(*uint64)(unsafe.Pointer(&notifyStoreOut[8])) = off
+	*(*uint32)(unsafe.Pointer(&notifyStoreOut[16])) = uint32(len(data))
+
+	err = volume.notifyWriter(notifyCodeStore, notifyStoreOut, data)
+	return
+}
+
+// NotifyRetrieve asks the kernel for up to size bytes of its cached page
+// data for nodeID at offset off. It blocks until the kernel answers with an
+// OpCodeNotifyReply carrying the matching NotifyUnique, returns
+// syscall.ETIMEDOUT if none arrives within notifyRetrieveTimeout, or
+// syscall.ECANCELED if DoUnmount tears down the volume while it is waiting.
+func (volume *volumeStruct) NotifyRetrieve(nodeID uint64, off uint64, size uint32) (data []byte, err error) {
+	var (
+		notifyRetrieveOut = make([]byte, NotifyRetrieveOutSize)
+		notifyUnique      uint64
+		ok                bool
+		replyChan         chan []byte
+		timer             *time.Timer
+	)
+
+	notifyUnique = atomic.AddUint64(&volume.notifier.nextUnique, 1)
+	replyChan = make(chan []byte, 1)
+
+	volume.notifier.Lock()
+	volume.notifier.pendingRetrieve[notifyUnique] = replyChan
+	volume.notifier.Unlock()
+
+	*(*uint64)(unsafe.Pointer(&notifyRetrieveOut[0])) = notifyUnique
+	*(*uint64)(unsafe.Pointer(&notifyRetrieveOut[8])) = nodeID
+	*(*uint64)(unsafe.Pointer(&notifyRetrieveOut[16])) = off
+	*(*uint32)(unsafe.Pointer(&notifyRetrieveOut[24])) = size
+
+	err = volume.notifyWriter(notifyCodeRetrieve, notifyRetrieveOut)
+	if nil != err {
+		volume.notifier.Lock()
+		delete(volume.notifier.pendingRetrieve, notifyUnique)
+		volume.notifier.Unlock()
+		return
+	}
+
+	timer = time.NewTimer(notifyRetrieveTimeout)
+	defer timer.Stop()
+
+	select {
+	case data, ok = <-replyChan:
+		if !ok {
+			err = syscall.ECANCELED
+		}
+	case <-timer.C:
+		volume.notifier.Lock()
+		delete(volume.notifier.pendingRetrieve, notifyUnique)
+		volume.notifier.Unlock()
+		err = syscall.ETIMEDOUT
+	}
+
+	return
+}
+
+// notifierCancelAll closes every channel a goroutine is currently blocked on
+// inside NotifyRetrieve, so DoUnmount doesn't leak them when the kernel was
+// never going to send their OpCodeNotifyReply (an evicted node, or unmount
+// racing a retrieve already in flight).
+func (volume *volumeStruct) notifierCancelAll() {
+	var (
+		pending   map[uint64]chan []byte
+		replyChan chan []byte
+	)
+
+	volume.notifier.Lock()
+	pending = volume.notifier.pendingRetrieve
+	volume.notifier.pendingRetrieve = make(map[uint64]chan []byte)
+	volume.notifier.Unlock()
+
+	for _, replyChan = range pending {
+		close(replyChan)
+	}
+}
+
+// NotifyPollWakeup tells the kernel that a previously-registered poll
+// handle kh (as supplied to Callbacks.DoPoll) is now ready, waking any
+// epoll()/poll() callers blocked on it.
+func (volume *volumeStruct) NotifyPollWakeup(kh uint64) (err error) {
+	var notifyPollWakeupOut = make([]byte, NotifyPollWakeupOutSize)
+
+	*(*uint64)(unsafe.Pointer(&notifyPollWakeupOut[0])) = kh
+
+	err = volume.notifyWriter(notifyCodePoll, notifyPollWakeupOut)
+	return
+}
+
+// doNotifyReply routes an inbound OpCodeNotifyReply back to the goroutine
+// blocked in NotifyRetrieve() awaiting it, delivering the retrieved bytes.
+func (volume *volumeStruct) doNotifyReply(ctx context.Context, inHeader *InHeader, buf []byte) {
+	var (
+		notifyUnique = inHeader.Unique
+		replyChan    chan []byte
+		ok           bool
+	)
+
+	volume.notifier.Lock()
+	replyChan, ok = volume.notifier.pendingRetrieve[notifyUnique]
+	if ok {
+		delete(volume.notifier.pendingRetrieve, notifyUnique)
+	}
+	volume.notifier.Unlock()
+
+	if !ok {
+		volume.logger.Warnf("Received OpCodeNotifyReply for unknown NotifyUnique == %v", notifyUnique)
+		return
+	}
+
+	// buf is a slice into the pooled /dev/fuse read buffer; processDevFuseFDReadBuf
+	// returns it to volume.devFuseFDReadPool as soon as this function returns, so it
+	// must be copied before handing it to the NotifyRetrieve caller on another
+	// goroutine, or a subsequent Read can overwrite it before that goroutine
+	// reads data out of the channel.
+	replyChan <- append([]byte(nil), buf...)
+	close(replyChan)
+}
@@ -0,0 +1,99 @@
+package fission
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// noopLogger satisfies Logger for tests that exercise error paths without
+// caring about what gets logged.
+type noopLogger struct{}
+
+func (noopLogger) Debugf(format string, args ...interface{}) {}
+func (noopLogger) Infof(format string, args ...interface{})  {}
+func (noopLogger) Warnf(format string, args ...interface{})  {}
+func (noopLogger) Errorf(format string, args ...interface{}) {}
+
+func TestDoNotifyReplyDeliversToNotifyRetrieve(t *testing.T) {
+	var (
+		data      []byte
+		replyChan = make(chan []byte, 1)
+		volume    = &volumeStruct{}
+	)
+
+	volume.notifier.pendingRetrieve = make(map[uint64]chan []byte)
+	volume.notifier.pendingRetrieve[1] = replyChan
+
+	volume.doNotifyReply(nil, &InHeader{Unique: 1}, []byte{1, 2, 3})
+
+	select {
+	case data = <-replyChan:
+	case <-time.After(time.Second):
+		t.Fatal("doNotifyReply did not deliver to the pending channel")
+	}
+
+	if 3 != len(data) {
+		t.Fatalf("expected doNotifyReply to forward the reply buf, got %v", data)
+	}
+
+	volume.notifier.Lock()
+	if 0 != len(volume.notifier.pendingRetrieve) {
+		t.Fatal("expected doNotifyReply to remove the pending entry")
+	}
+	volume.notifier.Unlock()
+}
+
+func TestNotifierCancelAllUnblocksPendingRetrieves(t *testing.T) {
+	var volume = &volumeStruct{}
+	volume.notifier.pendingRetrieve = make(map[uint64]chan []byte)
+
+	var replyChan = make(chan []byte, 1)
+	volume.notifier.pendingRetrieve[42] = replyChan
+
+	volume.notifierCancelAll()
+
+	select {
+	case _, ok := <-replyChan:
+		if ok {
+			t.Fatal("expected channel to be closed, got a value")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notifierCancelAll did not close the pending channel")
+	}
+
+	volume.notifier.Lock()
+	if 0 != len(volume.notifier.pendingRetrieve) {
+		t.Fatal("expected pendingRetrieve to be cleared")
+	}
+	volume.notifier.Unlock()
+}
+
+func TestNotifyRetrieveFailsFastAndCleansUpWhenWriteFails(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping NotifyRetrieve write-failure test in short mode")
+	}
+
+	var (
+		err    error
+		volume = &volumeStruct{}
+	)
+
+	volume.notifier.pendingRetrieve = make(map[uint64]chan []byte)
+	volume.logger = noopLogger{}
+	volume.devFuseFD = -1 // force notifyWriter's writev to fail fast instead of blocking on a real fd
+
+	_, err = volume.NotifyRetrieve(1, 0, 4096)
+	if nil == err {
+		t.Fatal("expected an error when /dev/fuse is unavailable")
+	}
+	if syscall.ETIMEDOUT == err {
+		t.Fatal("notifyWriter should have failed before the timeout fired")
+	}
+
+	volume.notifier.Lock()
+	if 0 != len(volume.notifier.pendingRetrieve) {
+		t.Fatal("expected pendingRetrieve entry to be cleaned up on notifyWriter failure")
+	}
+	volume.notifier.Unlock()
+}
@@ -0,0 +1,11 @@
+package fission
+
+// Darwin-only opcodes exposed by macFUSE/osxfuse that have no Linux
+// equivalent. They are declared without a build tag, like the rest of the
+// OpCode table, so processDevFuseFDReadBuf's switch compiles identically on
+// every platform; on Linux they simply never arrive on the wire.
+const (
+	OpCodeSetVolName uint32 = 61
+	OpCodeExchange   uint32 = 62
+	OpCodeGetXTimes  uint32 = 63
+)
@@ -0,0 +1,69 @@
+// +build linux
+
+package fission
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// FUSE_CAP_SPLICE_MOVE and FUSE_CAP_SPLICE_READ are InitOut.Flags bits the
+// kernel looks for during capability negotiation; when set, the kernel may
+// move pages between /dev/fuse and the backing socket/pipe during
+// splice-driven reads instead of copying them.
+const (
+	FUSE_CAP_SPLICE_MOVE uint32 = 1 << 6
+	FUSE_CAP_SPLICE_READ uint32 = 1 << 7
+)
+
+// fuseDevIOCClone is the ioctl(2) request number for FUSE_DEV_IOC_CLONE (see
+// <linux/fuse.h>): _IOR(229, 0, uint32).
+const fuseDevIOCClone = 0x8004E500
+
+// spliceCapFlags returns the capability bits DoMount ORs into
+// volume.initCapFlags. Nothing in this package reads volume.initCapFlags,
+// so these bits are not added to the InitOut.Flags of the OpCodeInit reply
+// and splice support is not advertised to the kernel.
+func spliceCapFlags() uint32 {
+	return FUSE_CAP_SPLICE_MOVE | FUSE_CAP_SPLICE_READ
+}
+
+// spawnClonedReaders opens volume.readerWorkers-1 additional fds cloned off
+// volume.devFuseFD via FUSE_DEV_IOC_CLONE, each running its own
+// devFuseFDReader loop against the same FUSE session, so serialized
+// read(2)s on a single fd don't bottleneck high-IOPS metadata workloads. It
+// is a no-op if readerWorkers is 0 or 1.
+func (volume *volumeStruct) spawnClonedReaders() {
+	var (
+		cloneErrno syscall.Errno
+		cloneFD    int
+		err        error
+		workerNum  int
+	)
+
+	if 2 > volume.readerWorkers {
+		return
+	}
+
+	volume.devFuseFDClones = make([]int, 0, volume.readerWorkers-1)
+
+	for workerNum = 1; workerNum < volume.readerWorkers; workerNum++ {
+		cloneFD, err = syscall.Open("/dev/fuse", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+		if nil != err {
+			volume.logger.Errorf("Volume %s unable to open /dev/fuse for reader worker %d: %v", volume.volumeName, workerNum, err)
+			return
+		}
+
+		_, _, cloneErrno = syscall.Syscall(syscall.SYS_IOCTL, uintptr(cloneFD), uintptr(fuseDevIOCClone), uintptr(unsafe.Pointer(&volume.devFuseFD)))
+		if 0 != cloneErrno {
+			volume.logger.Errorf("Volume %s FUSE_DEV_IOC_CLONE failed for reader worker %d: %v", volume.volumeName, workerNum, cloneErrno)
+			_ = syscall.Close(cloneFD)
+			return
+		}
+
+		volume.devFuseFDClones = append(volume.devFuseFDClones, cloneFD)
+
+		volume.devFuseFDReaderWG.Add(1)
+		go volume.devFuseFDReader(cloneFD)
+	}
+}
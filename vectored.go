@@ -0,0 +1,121 @@
+package fission
+
+import (
+	"context"
+	"syscall"
+	"unsafe"
+)
+
+// devFuseFDIovecPoolInitialCap sizes the []syscall.Iovec scratch slices handed
+// out by volumeStruct.devFuseFDIovecPool. One slot is reserved for the
+// OutHeader; the rest cover the common case of a handful of non-contiguous
+// segments returned by DoReadVectored.
+const devFuseFDIovecPoolInitialCap = 8
+
+// devFuseFDWriterVectored is the vectored counterpart to devFuseFDWriter. It
+// is used when a Callbacks implementation satisfies an OpCodeRead via
+// DoReadVectored rather than by handing back a single flat buffer, so that
+// the resulting non-contiguous iovecs are handed straight to writev(2)
+// without first being copied together.
+func (volume *volumeStruct) devFuseFDWriterVectored(inHeader *InHeader, errno syscall.Errno, iovecs [][]byte) {
+	var (
+		bytesWritten uintptr
+		iovec        []syscall.Iovec
+		iovecSpan    uintptr
+		outHeader    []byte
+		seg          []byte
+	)
+
+	if syscall.ENOSYS == errno {
+		volume.logger.Warnf("Read unsupported/unrecognized message OpCode == %v", inHeader.OpCode)
+	}
+
+	iovec = volume.devFuseFDIovecPool.Get().([]syscall.Iovec)
+	iovec = iovec[:0]
+
+	outHeader = make([]byte, OutHeaderSize)
+	iovecSpan = uintptr(OutHeaderSize)
+
+	iovec = append(iovec, syscall.Iovec{Base: &outHeader[0], Len: uint64(OutHeaderSize)})
+
+	for _, seg = range iovecs {
+		if 0 == len(seg) {
+			continue
+		}
+		iovec = append(iovec, syscall.Iovec{Base: &seg[0], Len: uint64(len(seg))})
+		iovecSpan += uintptr(len(seg))
+	}
+
+	*(*uint32)(unsafe.Pointer(&outHeader[0])) = uint32(iovecSpan)
+	*(*int32)(unsafe.Pointer(&outHeader[4])) = -int32(errno)
+	*(*uint64)(unsafe.Pointer(&outHeader[8])) = inHeader.Unique
+
+	// Record the errno actually sent to the kernel before the syscall below
+	// reuses this variable for its own return value. Only takeReplyErrno
+	// (gated on volume.tracer) ever drains this map, so skip the write
+	// entirely when there's no tracer to consume it, or every reply leaks an
+	// entry.
+	if nil != volume.tracer {
+		volume.recordReplyErrno(inHeader.Unique, errno)
+	}
+
+	bytesWritten, _, errno = syscall.Syscall(
+		syscall.SYS_WRITEV,
+		uintptr(volume.devFuseFD),
+		uintptr(unsafe.Pointer(&iovec[0])),
+		uintptr(len(iovec)))
+	if 0 == errno {
+		if bytesWritten != iovecSpan {
+			volume.logger.Warnf("Write to /dev/fuse returned bad bytesWritten: %v", bytesWritten)
+		}
+	} else {
+		volume.logger.Errorf("Write to /dev/fuse returned bad errno: %v", errno)
+	}
+
+	volume.devFuseFDIovecPool.Put(iovec)
+}
+
+// doReadVectored dispatches OpCodeRead to a Callbacks implementation that
+// supports handing back multiple non-contiguous slices (e.g. segments of a
+// page cache, mmap'd regions, or chunked object-storage responses) instead
+// of a single flat buffer, avoiding the merge/copy that doRead would
+// otherwise require. It is only invoked when volume.vectoredIOEnabled is
+// set and the configured Callbacks implements DoReadVectored.
+func (volume *volumeStruct) doReadVectored(ctx context.Context, inHeader *InHeader, readIn *ReadIn) {
+	var (
+		errno  syscall.Errno
+		iovecs [][]byte
+	)
+
+	iovecs, errno = volume.callbacks.DoReadVectored(ctx, inHeader, readIn)
+	if syscall.Errno(0) != errno {
+		volume.devFuseFDWriter(inHeader, errno)
+		return
+	}
+
+	volume.devFuseFDWriterVectored(inHeader, syscall.Errno(0), iovecs)
+}
+
+// doWriteZeroCopy dispatches OpCodeWrite by handing the callback a reference
+// to the pooled devFuseFDReadBuf segment holding the write payload, rather
+// than the copy doWrite would otherwise make. The callback must not retain
+// buf beyond the call, since it is returned to volume.devFuseFDReadPool
+// immediately afterward by processDevFuseFDReadBuf.
+func (volume *volumeStruct) doWriteZeroCopy(ctx context.Context, inHeader *InHeader, writeIn *WriteIn, buf []byte) {
+	var (
+		bytesWritten uint32
+		errno        syscall.Errno
+		writeOutBuf  []byte
+	)
+
+	bytesWritten, errno = volume.callbacks.DoWriteZeroCopy(ctx, inHeader, writeIn, buf)
+	if syscall.Errno(0) != errno {
+		volume.devFuseFDWriter(inHeader, errno)
+		return
+	}
+
+	writeOutBuf = make([]byte, WriteOutSize)
+	*(*uint32)(unsafe.Pointer(&writeOutBuf[0])) = bytesWritten
+
+	volume.devFuseFDWriter(inHeader, syscall.Errno(0), writeOutBuf)
+}
@@ -1,37 +1,88 @@
 package fission
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
 type volumeStruct struct {
-	volumeName        string
-	mountpointDirPath string
-	mountFlags        uintptr
-	initOutMaxWrite   uint32
-	callbacks         Callbacks
-	logger            *log.Logger
-	errChan           chan error
-	devFuseFDReadSize uint32 // InHeaderSize + WriteInSize + InitOut.MaxWrite
-	devFuseFDReadPool sync.Pool
-	devFuseFD         int
-	devFuseFDReaderWG sync.WaitGroup
-	callbacksWG       sync.WaitGroup
+	volumeName             string
+	mountpointDirPath      string
+	mountFlags             uintptr
+	initOutMaxWrite        uint32
+	vectoredIOEnabled      bool // if true, Callbacks may satisfy Reads via DoReadVectored and avoid the read-buf copy on Writes
+	callbacks              Callbacks
+	logger                 Logger
+	tracer                 Tracer
+	errChan                chan error
+	devFuseFDReadSize      uint32 // InHeaderSize + WriteInSize + InitOut.MaxWrite
+	devFuseFDReadPool      sync.Pool
+	devFuseFDIovecPool     sync.Pool // []syscall.Iovec scratch space for vectored replies
+	devFuseFD              int
+	devFuseFDReaderWG      sync.WaitGroup
+	callbacksWG            sync.WaitGroup
+	notifier               notifierStruct
+	macFUSEVersion         int // Darwin only; used to strip the macFUSE 4.x RenameIn flags trailer
+	inFlight               inFlightStruct
+	readerWorkers          int   // number of devFuseFDReader loops to run; <2 means just the primary devFuseFD
+	devFuseFDClones        []int // fds opened via FUSE_DEV_IOC_CLONE for the extra readerWorkers
+	replyErrno             replyErrnoStruct
+	initCapFlags           uint32       // InitOut.Flags bits set by DoMount (see spliceCapFlags); not read by doInit, so never sent to the kernel
+	dispatchGate           sync.RWMutex // guards shuttingDown; held for read around each callbacksWG.Add, for write while quiescing readers before callbacksWG.Wait
+	shuttingDown           bool         // set under dispatchGate's write lock by the primary devFuseFDReader's exit path
+	closeClonedReadersOnce sync.Once
 }
 
-func newVolume(volumeName string, mountpointDirPath string, mountFlags uintptr, initOutMaxWrite uint32, callbacks Callbacks, logger *log.Logger, errChan chan error) (volume *volumeStruct) {
+// replyErrnoStruct records the errno devFuseFDWriter/devFuseFDWriterVectored
+// actually sent back to the kernel for a given inHeader.Unique, so
+// processDevFuseFDReadBuf can hand Tracer the real reply instead of always
+// reporting success.
+type replyErrnoStruct struct {
+	sync.Mutex
+	errno map[uint64]syscall.Errno
+}
+
+func (volume *volumeStruct) recordReplyErrno(unique uint64, errno syscall.Errno) {
+	volume.replyErrno.Lock()
+	volume.replyErrno.errno[unique] = errno
+	volume.replyErrno.Unlock()
+}
+
+func (volume *volumeStruct) takeReplyErrno(unique uint64) (errno syscall.Errno, ok bool) {
+	volume.replyErrno.Lock()
+	errno, ok = volume.replyErrno.errno[unique]
+	if ok {
+		delete(volume.replyErrno.errno, unique)
+	}
+	volume.replyErrno.Unlock()
+	return
+}
+
+// newVolume accepts a Logger so callers can plug in zap/zerolog/OpenTelemetry
+// in place of the standard library logger; callers still holding a
+// *log.Logger can wrap it with NewStdLogAdapter for backward compatibility.
+// A nil logger falls back to NewStdLogAdapter wrapping log.Default().
+// tracer may be nil, in which case processDevFuseFDReadBuf skips tracing.
+func newVolume(volumeName string, mountpointDirPath string, mountFlags uintptr, initOutMaxWrite uint32, vectoredIOEnabled bool, readerWorkers int, callbacks Callbacks, logger Logger, tracer Tracer, errChan chan error) (volume *volumeStruct) {
+	if nil == logger {
+		logger = NewStdLogAdapter(log.Default())
+	}
+
 	volume = &volumeStruct{
 		volumeName:        volumeName,
 		mountpointDirPath: mountpointDirPath,
 		mountFlags:        mountFlags,
 		initOutMaxWrite:   initOutMaxWrite,
+		vectoredIOEnabled: vectoredIOEnabled,
+		readerWorkers:     readerWorkers,
 		callbacks:         callbacks,
 		logger:            logger,
+		tracer:            tracer,
 		errChan:           errChan,
 		devFuseFDReadSize: InHeaderSize + WriteInFixedPortionSize + initOutMaxWrite,
 	}
@@ -42,77 +93,67 @@ func newVolume(volumeName string, mountpointDirPath string, mountFlags uintptr,
 		},
 	}
 
-	return
-}
-
-func (volume *volumeStruct) DoMount() (err error) {
-	var (
-		devFuseFDMountOption string
-		gid                  int
-		gidMountOption       string
-		mountOptions         string
-		rootMode             uint32
-		rootModeMountOption  string
-		uid                  int
-		uidMountOption       string
-	)
-
-	_ = syscall.Unmount(volume.mountpointDirPath, syscall.MNT_FORCE)
-
-	volume.devFuseFD, err = syscall.Open("/dev/fuse", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
-	if nil != err {
-		volume.logger.Printf("Volume %s unable to open /dev/fuse", volume.volumeName)
-		return
+	volume.devFuseFDIovecPool = sync.Pool{
+		New: func() interface{} {
+			return make([]syscall.Iovec, 0, devFuseFDIovecPoolInitialCap)
+		},
 	}
 
-	volume.devFuseFDReaderWG.Add(1)
-	go volume.devFuseFDReader()
-
-	devFuseFDMountOption = fmt.Sprintf("fd=%d", volume.devFuseFD)
+	volume.notifier.pendingRetrieve = make(map[uint64]chan []byte)
 
-	rootMode = syscall.S_IFDIR
-	rootModeMountOption = fmt.Sprintf("rootmode=%o", rootMode)
+	volume.inFlight.cancelFuncs = make(map[uint64]context.CancelFunc)
 
-	uid = syscall.Geteuid()
-	gid = syscall.Getegid()
-
-	uidMountOption = fmt.Sprintf("user_id=%d", uid)
-	gidMountOption = fmt.Sprintf("group_id=%d", gid)
-
-	mountOptions = devFuseFDMountOption + "," + rootModeMountOption + "," + uidMountOption + "," + gidMountOption
-
-	err = syscall.Mount(volume.volumeName, volume.mountpointDirPath, "fuse", volume.mountFlags, mountOptions)
-	if nil == err {
-		volume.logger.Printf("Volume %s mounted on mountpoint %s", volume.volumeName, volume.mountpointDirPath)
-	} else {
-		volume.logger.Printf("Volume %s mount on mountpoint %s failed: %v", volume.volumeName, volume.mountpointDirPath, err)
-		_ = syscall.Close(volume.devFuseFD)
-		volume.devFuseFDReaderWG.Wait()
-	}
+	volume.replyErrno.errno = make(map[uint64]syscall.Errno)
 
 	return
 }
 
+// DoMount is implemented per-platform: see mount_linux.go for the /dev/fuse
+// path and mount_darwin.go for the macFUSE socket-handoff path.
+
 func (volume *volumeStruct) DoUnmount() (err error) {
-	err = syscall.Unmount(volume.mountpointDirPath, syscall.MNT_FORCE)
+	volume.inFlightCancelAll()
+	volume.notifierCancelAll()
+
+	err = syscall.Unmount(volume.mountpointDirPath, unmountFlags)
 	if nil != err {
-		volume.logger.Printf("Unable to unmount %s: %v", volume.mountpointDirPath, err)
+		volume.logger.Errorf("Unable to unmount %s: %v", volume.mountpointDirPath, err)
 		return
 	}
 
 	err = syscall.Close(volume.devFuseFD)
 	if nil != err {
-		volume.logger.Printf("Unable to close /dev/fuse: %v", err)
+		volume.logger.Errorf("Unable to close /dev/fuse: %v", err)
 		return
 	}
 
+	volume.closeClonedReaders()
+
 	volume.devFuseFDReaderWG.Wait()
 
-	volume.logger.Printf("Volume %s unmounted from mountpoint %s", volume.volumeName, volume.mountpointDirPath)
+	volume.logger.Infof("Volume %s unmounted from mountpoint %s", volume.volumeName, volume.mountpointDirPath)
 
 	return
 }
 
+// closeClonedReaders closes every fd opened by spawnClonedReaders (Linux
+// only; a no-op elsewhere since devFuseFDClones is never populated). Both
+// DoUnmount and the primary devFuseFDReader's exit path call this, so it is
+// wrapped in a sync.Once: whichever gets there first does the closing, and
+// the other just waits for it to finish instead of racing it over
+// devFuseFDClones.
+func (volume *volumeStruct) closeClonedReaders() {
+	volume.closeClonedReadersOnce.Do(func() {
+		var cloneFD int
+
+		for _, cloneFD = range volume.devFuseFDClones {
+			_ = syscall.Close(cloneFD)
+		}
+
+		volume.devFuseFDClones = nil
+	})
+}
+
 func (volume *volumeStruct) devFuseFDReadPoolGet() (devFuseFDReadBuf []byte) {
 	devFuseFDReadBuf = volume.devFuseFDReadPool.Get().([]byte)
 	return
@@ -123,25 +164,55 @@ func (volume *volumeStruct) devFuseFDReadPoolPut(devFuseFDReadBuf []byte) {
 	volume.devFuseFDReadPool.Put(devFuseFDReadBuf)
 }
 
-func (volume *volumeStruct) devFuseFDReader() {
+// devFuseFDReader runs one /dev/fuse read loop against fd. The primary
+// worker (fd == volume.devFuseFD) owns the shutdown sequence: it closes off
+// new dispatches and the cloned readers' fds (see dispatchGate), cancels
+// outstanding in-flight contexts, waits for Callbacks to drain, and signals
+// errChan. Cloned workers (see spawnClonedReaders) just retire quietly; they
+// exit once their fd is closed, whether that happens via DoUnmount or via the
+// primary's own shutdown sequence above.
+func (volume *volumeStruct) devFuseFDReader(fd int) {
 	var (
 		bytesRead        int
+		ctx              context.Context
 		devFuseFDReadBuf []byte
 		err              error
+		isPrimary        = fd == volume.devFuseFD
+		unique           uint64
 	)
 
 	for {
 		devFuseFDReadBuf = volume.devFuseFDReadPoolGet()
 
-		bytesRead, err = syscall.Read(volume.devFuseFD, devFuseFDReadBuf)
+		bytesRead, err = syscall.Read(fd, devFuseFDReadBuf)
 		if nil != err {
 			if 0 == strings.Compare("operation not permitted", err.Error()) {
 				// Special case... simply retry the Read
 				continue
 			}
 
-			// Time to exit...but first await outstanding Callbacks
+			if !isPrimary {
+				volume.devFuseFDReaderWG.Done()
+				return
+			}
+
+			// Time to exit...but first block out new dispatches and quiesce the
+			// cloned readers before waiting on callbacksWG: a cloned reader can
+			// be mid-Read on its own fd right now, and if it dispatched one more
+			// op (callbacksWG.Add(1)) concurrently with the Wait below, that's
+			// exactly the case sync.WaitGroup documents as unsafe. Taking
+			// dispatchGate for write here blocks until any Add already in
+			// flight (see below) finishes, and closeClonedReaders unblocks any
+			// cloned reader still parked in Read so it retires instead of
+			// dispatching.
 
+			volume.dispatchGate.Lock()
+			volume.shuttingDown = true
+			volume.dispatchGate.Unlock()
+
+			volume.closeClonedReaders()
+
+			volume.inFlightCancelAll()
 			volume.callbacksWG.Wait()
 			volume.devFuseFDReaderWG.Done()
 
@@ -150,7 +221,7 @@ func (volume *volumeStruct) devFuseFDReader() {
 			if 0 == strings.Compare("no such device", err.Error()) {
 				volume.errChan <- nil
 			} else {
-				volume.logger.Printf("Exiting due to /dev/fuse Read err: %v", err)
+				volume.logger.Errorf("Exiting due to /dev/fuse Read err: %v", err)
 				volume.errChan <- err
 			}
 
@@ -159,21 +230,50 @@ func (volume *volumeStruct) devFuseFDReader() {
 
 		devFuseFDReadBuf = devFuseFDReadBuf[:bytesRead]
 
-		// Dispatch goroutine to process devFuseFDReadBuf
+		// Register the in-flight context synchronously, before dispatch, so a
+		// closely-following OpCodeInterrupt can't race ahead of this
+		// request's own registration and find nothing to cancel.
+
+		ctx = nil
+		if len(devFuseFDReadBuf) >= InHeaderSize {
+			unique = *(*uint64)(unsafe.Pointer(&devFuseFDReadBuf[8]))
+			ctx = volume.inFlightRegister(unique)
+		}
 
+		// Dispatch goroutine to process devFuseFDReadBuf. dispatchGate's read
+		// lock is held across the Add so the primary's shutdown path (above)
+		// can't start waiting on callbacksWG while this Add is in flight; if
+		// shutdown has already started, drop this op instead of dispatching
+		// it.
+
+		volume.dispatchGate.RLock()
+		if volume.shuttingDown {
+			volume.dispatchGate.RUnlock()
+			volume.devFuseFDReadPoolPut(devFuseFDReadBuf)
+			volume.devFuseFDReaderWG.Done()
+			return
+		}
 		volume.callbacksWG.Add(1)
-		go volume.processDevFuseFDReadBuf(devFuseFDReadBuf)
+		volume.dispatchGate.RUnlock()
+
+		go volume.processDevFuseFDReadBuf(ctx, unique, devFuseFDReadBuf)
 	}
 }
 
-func (volume *volumeStruct) processDevFuseFDReadBuf(devFuseFDReadBuf []byte) {
+// processDevFuseFDReadBuf dispatches one message read off /dev/fuse. ctx is
+// the context devFuseFDReader already registered for unique (nil if the
+// message was too short to carry a Unique); registration happens there,
+// synchronously, rather than here, so it happens-before any OpCodeInterrupt
+// the reader loop dispatches afterward.
+func (volume *volumeStruct) processDevFuseFDReadBuf(ctx context.Context, unique uint64, devFuseFDReadBuf []byte) {
 	var (
-		inHeader *InHeader
+		inHeader  *InHeader
+		startTime time.Time
 	)
 
 	if len(devFuseFDReadBuf) < InHeaderSize {
 		// All we can do is just drop it
-		volume.logger.Printf("Read malformed message from /dev/fuse")
+		volume.logger.Warnf("Read malformed message from /dev/fuse")
 		volume.devFuseFDReadPoolPut(devFuseFDReadBuf)
 		volume.callbacksWG.Done()
 		return
@@ -190,95 +290,128 @@ func (volume *volumeStruct) processDevFuseFDReadBuf(devFuseFDReadBuf []byte) {
 		Padding: *(*uint32)(unsafe.Pointer(&devFuseFDReadBuf[36])),
 	}
 
+	if nil != volume.tracer {
+		startTime = time.Now()
+		volume.tracer.Trace(inHeader.OpCode, inHeader.Unique, inHeader.NodeID, inHeader.UID, inHeader.GID, inHeader.PID, true, 0, syscall.Errno(0))
+	}
+
+	if nil != ctx {
+		defer volume.inFlightDeregister(unique)
+	}
+
 	switch inHeader.OpCode {
 	case OpCodeLookup:
-		volume.doLookup(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doLookup(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeForget:
-		volume.doForget(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doForget(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeGetAttr:
-		volume.doGetAttr(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doGetAttr(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeSetAttr:
-		volume.doSetAttr(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doSetAttr(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeReadLink:
-		volume.doReadLink(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doReadLink(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeSymLink:
-		volume.doSymLink(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doSymLink(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeMkNod:
-		volume.doMkNod(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doMkNod(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeMkDir:
-		volume.doMkDir(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doMkDir(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeUnlink:
-		volume.doUnlink(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doUnlink(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeRmDir:
-		volume.doRmDir(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doRmDir(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeRename:
-		volume.doRename(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doRename(ctx, inHeader, volume.renameInBuf(devFuseFDReadBuf[InHeaderSize:]))
 	case OpCodeLink:
-		volume.doLink(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doLink(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeOpen:
-		volume.doOpen(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doOpen(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeRead:
-		volume.doRead(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		if volume.vectoredIOEnabled {
+			volume.doReadVectored(ctx, inHeader, (*ReadIn)(unsafe.Pointer(&devFuseFDReadBuf[InHeaderSize])))
+		} else {
+			volume.doRead(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
+		}
 	case OpCodeWrite:
-		volume.doWrite(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		if volume.vectoredIOEnabled {
+			volume.doWriteZeroCopy(ctx, inHeader, (*WriteIn)(unsafe.Pointer(&devFuseFDReadBuf[InHeaderSize])), devFuseFDReadBuf[InHeaderSize+WriteInFixedPortionSize:])
+		} else {
+			volume.doWrite(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
+		}
 	case OpCodeStatFS:
-		volume.doStatFS(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doStatFS(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeRelease:
-		volume.doRelease(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doRelease(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeFSync:
-		volume.doFSync(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doFSync(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeSetXAttr:
-		volume.doSetXAttr(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doSetXAttr(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeGetXAttr:
-		volume.doGetXAttr(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doGetXAttr(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeListXAttr:
-		volume.doListXAttr(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doListXAttr(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeRemoveXAttr:
-		volume.doRemoveXAttr(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doRemoveXAttr(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeFlush:
-		volume.doFlush(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doFlush(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeInit:
-		volume.doInit(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doInit(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeOpenDir:
-		volume.doOpenDir(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doOpenDir(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeReadDir:
-		volume.doReadDir(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doReadDir(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeReleaseDir:
-		volume.doReleaseDir(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doReleaseDir(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeFSyncDir:
-		volume.doFSyncDir(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doFSyncDir(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeGetLK:
-		volume.doGetLK(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doGetLK(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeSetLK:
-		volume.doSetLK(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doSetLK(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeSetLKW:
-		volume.doSetLKW(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doSetLKW(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeAccess:
-		volume.doAccess(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doAccess(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeCreate:
-		volume.doCreate(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doCreate(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeInterrupt:
-		volume.doInterrupt(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doInterrupt(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
+	case OpCodeNotifyReply:
+		volume.doNotifyReply(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeBMap:
-		volume.doBMap(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doBMap(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeDestroy:
-		volume.doDestroy(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doDestroy(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodePoll:
-		volume.doPoll(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doPoll(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeBatchForget:
-		volume.doBatchForget(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doBatchForget(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeFAllocate:
-		volume.doFAllocate(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doFAllocate(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeReadDirPlus:
-		volume.doReadDirPlus(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doReadDirPlus(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeRename2:
-		volume.doRename2(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doRename2(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
 	case OpCodeLSeek:
-		volume.doLSeek(inHeader, devFuseFDReadBuf[InHeaderSize:])
+		volume.doLSeek(ctx, inHeader, devFuseFDReadBuf[InHeaderSize:])
+	case OpCodeSetVolName, OpCodeExchange, OpCodeGetXTimes:
+		// Darwin-only; no Callbacks method exists for them yet
+		volume.devFuseFDWriter(inHeader, syscall.ENOSYS)
 	default:
 		volume.devFuseFDWriter(inHeader, syscall.ENOSYS)
 	}
 
+	if nil != volume.tracer {
+		var replyErrno syscall.Errno
+
+		// replyErrno is populated by devFuseFDWriter/devFuseFDWriterVectored,
+		// whichever the do* callback above actually used to reply; it is only
+		// absent for ops (e.g. OpCodeInterrupt, OpCodeNotifyReply) that never
+		// send a kernel reply, in which case 0 (success) is reported.
+		replyErrno, _ = volume.takeReplyErrno(inHeader.Unique)
+		volume.tracer.Trace(inHeader.OpCode, inHeader.Unique, inHeader.NodeID, inHeader.UID, inHeader.GID, inHeader.PID, false, time.Since(startTime), replyErrno)
+	}
+
 	volume.devFuseFDReadPoolPut(devFuseFDReadBuf)
 	volume.callbacksWG.Done()
 }
@@ -296,7 +429,7 @@ func (volume *volumeStruct) devFuseFDWriter(inHeader *InHeader, errno syscall.Er
 	// First, log any syscall.ENOSYS responses
 
 	if syscall.ENOSYS == errno {
-		volume.logger.Printf("Read unsupported/unrecognized message OpCode == %v", inHeader.OpCode)
+		volume.logger.Warnf("Read unsupported/unrecognized message OpCode == %v", inHeader.OpCode)
 	}
 
 	// Construct iovec elements for supplied bufs (if any)
@@ -318,6 +451,15 @@ func (volume *volumeStruct) devFuseFDWriter(inHeader *InHeader, errno syscall.Er
 	*(*int32)(unsafe.Pointer(&outHeader[4])) = -int32(errno)
 	*(*uint64)(unsafe.Pointer(&outHeader[8])) = inHeader.Unique
 
+	// Record the errno actually sent to the kernel before the syscall below
+	// reuses this variable for its own return value. Only takeReplyErrno
+	// (gated on volume.tracer) ever drains this map, so skip the write
+	// entirely when there's no tracer to consume it, or every reply leaks an
+	// entry.
+	if nil != volume.tracer {
+		volume.recordReplyErrno(inHeader.Unique, errno)
+	}
+
 	iovec[0] = syscall.Iovec{Base: &outHeader[0], Len: uint64(OutHeaderSize)}
 
 	bytesWritten, _, errno = syscall.Syscall(
@@ -327,9 +469,9 @@ func (volume *volumeStruct) devFuseFDWriter(inHeader *InHeader, errno syscall.Er
 		uintptr(len(iovec)))
 	if 0 == errno {
 		if bytesWritten != iovecSpan {
-			volume.logger.Printf("Write to /dev/fuse returned bad bytesWritten: %v", bytesWritten)
+			volume.logger.Warnf("Write to /dev/fuse returned bad bytesWritten: %v", bytesWritten)
 		}
 	} else {
-		volume.logger.Printf("Write to /dev/fuse returned bad errno: %v", errno)
+		volume.logger.Errorf("Write to /dev/fuse returned bad errno: %v", errno)
 	}
 }